@@ -19,17 +19,23 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"net/http"
 	"os"
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/albus01/ibenchmark/latency"
+	"golang.org/x/net/http2"
 )
 
 const (
@@ -57,6 +63,20 @@ var CipherSuites = map[string]uint16{
 	"TLS_FALLBACK_SCSV": uint16(0x5600),
 }
 
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var CurveIDs = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
 var (
 	help        *bool   = flag.Bool("h", false, "show help")
 	url         *string = flag.String("u", "https://0.0.0.0:28080/", "server url")
@@ -70,6 +90,24 @@ var (
 	headers     *string = flag.String("H", "", "request Headers,empty default")
 	body        *string = flag.String("B", "", "request Body,empty default")
 	out         *bool   = flag.Bool("o", false, "print response body")
+	protocol    *string = flag.String("p", "http1", "protocol mode:http1|h2,http1 default. h2 opens one TLS connection per worker and multiplexes streams over it")
+	streams     *int    = flag.Int("streams", 1, "concurrent HTTP/2 streams per connection,only used when -p h2,1 default")
+	maxFrameSize *int   = flag.Int("h2-max-frame-size", 65535, "SETTINGS_MAX_FRAME_SIZE advertised by the HTTP/2 client (http2.Transport.MaxReadFrameSize),only used when -p h2,65535 default. golang.org/x/net/http2 hardcodes the per-stream/connection flow-control windows,so there's no client knob for those to expose here")
+	tlsVersion  *string = flag.String("tls", "1.2", "minimum/negotiated TLS version:1.0|1.1|1.2|1.3,1.2 default")
+	groups      *string = flag.String("groups", "", "comma separated key share groups for the TLS handshake,e.g. X25519,P256. empty uses crypto/tls defaults")
+	resume      *string = flag.String("resume", "full", "TLS session resumption mode:full|ticket|psk|0rtt,full default. ticket/psk warm up a shared session cache before benchmarking; 0rtt additionally marks handshakes eligible for the server's early-data acceptance, though crypto/tls has no client-side early-data API so it behaves like ticket")
+	histOut     *string = flag.String("hist-out", "", "write the merged request/handshake latency histograms to this file as plain-text value,count lines per bucket (not the binary HdrHistogram log encoding),empty disables,empty default")
+	rate        *float64 = flag.Float64("rate", 0, "aggregate open-model request rate in req/s,0 disables open-model load (closed-model,default). when set,request start times are scheduled ahead of time instead of waiting for the previous response,so tail latency under overload isn't hidden by coordinated omission")
+	arrival     *string  = flag.String("arrival", "fixed", "open-model inter-arrival process when -rate>0:fixed|poisson,fixed default")
+	openWorkers *int     = flag.Int("open-workers", 0, "bounded dispatch pool size for open-model load,0 uses -c,0 default")
+	script      *string  = flag.String("script", "", "path to a request plan (JSON array of templated requests,see requestSpec),empty default. when set,-u's path,-m,-H and -B are ignored in favor of the plan")
+	values      *string  = flag.String("values", "", "path to a CSV file of per-request values,exposed to -script templates as {{.Values.<column>}},empty default")
+	cert        *string  = flag.String("cert", "", "path to a PEM client certificate for mTLS,empty disables client auth. with -cert-rotate>0,must contain a %d verb,e.g. client-%d.pem")
+	key         *string  = flag.String("key", "", "path to the PEM private key matching -cert. with -cert-rotate>0,must contain a %d verb,e.g. client-%d.key")
+	ca          *string  = flag.String("ca", "", "path to a PEM root CA bundle to verify the server certificate against,empty uses the system roots")
+	serverName  *string  = flag.String("servername", "", "TLS ServerName (SNI) to present and verify the server certificate against,empty derives it from -u's host")
+	certRotate  *int     = flag.Int("cert-rotate", 0, "round-robin across N client certificates built from -cert/-key's %d verb,one slot per worker,0 disables rotation (always -cert/-key as given)")
+	insecure    *bool    = flag.Bool("insecure", false, "skip server certificate verification,false default (verify against -ca or the system roots)")
 )
 
 var (
@@ -79,12 +117,48 @@ var (
 	address      string
 	path         string
 	swithHttp    bool            = false
+	swithH2      bool            = false
 	network      string          = "tcp"
 	servers      map[string]bool = make(map[string]bool)
+	serversMu    sync.Mutex // guards servers,written concurrently by h2Worker's per-stream goroutines
 	header       http.Header     = make(http.Header)
 	cipherSuites []uint16
+	sessionCache tls.ClientSessionCache
+	scriptPlan   *ScriptPlan
+	clientCerts  []tls.Certificate // client certs to present for mTLS,one slot per -cert-rotate rotation (or a single slot without rotation)
+	rootCAs      *x509.CertPool    // -ca's pool,nil uses the system roots
+)
+
+//recordServers merges newly seen Server header values into the shared servers set,under
+//serversMu since h2Worker's per-stream goroutines observe responses concurrently.
+func recordServers(values []string) {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	for _, v := range values {
+		servers[v] = true
+	}
+}
+
+// The latency histograms cover 1 microsecond to 60 seconds with 3 significant
+// digits of resolution, tracked separately for request latency and for
+// TLS handshake latency.
+const (
+	latencyLowest  = int64(1)
+	latencyHighest = int64(60 * 1000 * 1000)
+	latencySigDigs = 3
 )
 
+// warmUpReadTimeout bounds the post-handshake drain read in warmUpSession,so a server that
+// never sends a NewSessionTicket (or any data) can't hang session warm-up.
+const warmUpReadTimeout = 2 * time.Second
+
+// EndpointStats tallies success/failure per -script endpoint,keyed by the rendered
+// request path,replacing a single global Non2XXCode count when requests vary per call.
+type EndpointStats struct {
+	Success int
+	Failure int
+}
+
 type Reporter struct {
 	Server              string
 	Hostname            string
@@ -93,21 +167,144 @@ type Reporter struct {
 	Headers             string
 	ContentLength       int64
 	Concurrency         int
-	TimeTaken           int64
 	TimeDur             int64
 	TotalRequest        int
 	FailedRequest       int
 	RequestPerSecond    int
 	ConnectionPerSecond int
 	Non2XXCode          int
+	StreamsPerSecond    int
+	AvgStreamsPerConn   float64
+	RstStreamCount      int
+	GoAwayCount         int
+	FullHandshakes      int
+	ResumedHandshakes   int
+	ZeroRTTEligible     int // resumed handshakes under -resume=0rtt; crypto/tls has no client-side early-data API, so this is eligibility, not confirmed 0-RTT accept
+	CertFailures        int // TLS handshake failures attributable to a client/server certificate problem
+	TransportFailures   int // TLS handshake failures from a dial/network problem unrelated to certificates
+	Dropped             int // open-model requests discarded because the dispatch pool was backlogged
+	Late                int // open-model requests dispatched well behind their scheduled start
+	Latency             *latency.Recorder // per-request wall time
+	Handshake           *latency.Recorder // per-handshake wall time
+	Endpoints           map[string]*EndpointStats // per -script endpoint,keyed by rendered path
+	endpointsMu         sync.Mutex
+	countersMu          sync.Mutex // guards TotalRequest/FailedRequest/Non2XXCode/StreamsPerSecond/RstStreamCount/GoAwayCount/ContentLength/FullHandshakes/ResumedHandshakes/ZeroRTTEligible/CertFailures/TransportFailures/Late,written concurrently by h2Worker's per-stream goroutines and openModelDriver's pool workers
+}
+
+//recordEndpoint tallies a completed request's success/failure under its rendered path
+func (r *Reporter) recordEndpoint(path string, success bool) {
+	r.endpointsMu.Lock()
+	defer r.endpointsMu.Unlock()
+	if r.Endpoints == nil {
+		r.Endpoints = make(map[string]*EndpointStats)
+	}
+	stats := r.Endpoints[path]
+	if stats == nil {
+		stats = &EndpointStats{}
+		r.Endpoints[path] = stats
+	}
+	if success {
+		stats.Success += 1
+	} else {
+		stats.Failure += 1
+	}
+}
+
+//recordStreamResult tallies one HTTP/2 stream's outcome. h2Worker fires *streams of these
+//concurrently on the same connection,so the shared counters go through countersMu.
+func (r *Reporter) recordStreamResult(pr *PlannedRequest, resp *http.Response, err error) {
+	if err != nil {
+		fmt.Println(fmt.Sprintf("HTTP/2 GET ERROR %v", err))
+	}
+	r.countersMu.Lock()
+	r.TotalRequest += 1
+	r.StreamsPerSecond += 1
+	if err != nil {
+		r.FailedRequest += 1
+		if _, ok := err.(http2.StreamError); ok {
+			r.RstStreamCount += 1
+		}
+		var goAwayErr http2.GoAwayError
+		if errors.As(err, &goAwayErr) {
+			r.GoAwayCount += 1
+		}
+	}
+	if resp != nil && *script == "" && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		r.Non2XXCode += 1
+	}
+	if resp != nil {
+		r.ContentLength = resp.ContentLength
+	}
+	r.countersMu.Unlock()
+	if resp != nil {
+		if *script != "" {
+			r.recordEndpoint(pr.Path, pr.Success(resp.StatusCode))
+		}
+		recordServers(resp.Header["Server"])
+	}
+}
+
+// NewReporter builds a Reporter with its latency histograms ready to record.
+func NewReporter() *Reporter {
+	return &Reporter{
+		Latency:   latency.NewRecorder(latencyLowest, latencyHighest, latencySigDigs),
+		Handshake: latency.NewRecorder(latencyLowest, latencyHighest, latencySigDigs),
+	}
 }
 
 func (r *Reporter) Printer() error {
-	report := fmt.Sprintf("Server Software:%s\nServer Hostname:%s\nServer Port:%s\n\nRequest Headers:\n%s\n\nDocument Path:%s\nDocument Length:%d\n\nConcurrency:%d\nTime Duration:%dms\nAvg Time Taken:%dms\n\nComplete Requests:%d\nFailed Request:%d\n\nRequest Per Second:%d\nConnections Per Second:%d\n\nNon2XXCode:%d\n\n", r.Server, r.Hostname, r.Port, r.Headers, r.Path, r.ContentLength, r.Concurrency, r.TimeDur, r.TimeTaken/1000/int64(r.TotalRequest), r.TotalRequest, r.FailedRequest, r.RequestPerSecond, r.ConnectionPerSecond, r.Non2XXCode)
+	report := fmt.Sprintf("Server Software:%s\nServer Hostname:%s\nServer Port:%s\n\nRequest Headers:\n%s\n\nDocument Path:%s\nDocument Length:%d\n\nConcurrency:%d\nTime Duration:%dms\n\nComplete Requests:%d\nFailed Request:%d\n\nRequest Per Second:%d\nConnections Per Second:%d\n\nNon2XXCode:%d\n\n", r.Server, r.Hostname, r.Port, r.Headers, r.Path, r.ContentLength, r.Concurrency, r.TimeDur, r.TotalRequest, r.FailedRequest, r.RequestPerSecond, r.ConnectionPerSecond, r.Non2XXCode)
 	fmt.Println(report)
+	fmt.Println(formatLatencyReport("Request Latency", r.Latency))
+	if swithH2 {
+		h2report := fmt.Sprintf("Streams Per Second:%d\nAvg Streams Per Connection:%.2f\n\nRST_STREAM Count:%d\nGOAWAY Count:%d\n\n", r.StreamsPerSecond, r.AvgStreamsPerConn, r.RstStreamCount, r.GoAwayCount)
+		fmt.Println(h2report)
+	}
+	if !swithHttp {
+		tlsReport := fmt.Sprintf("Full Handshakes:%d\nResumed Handshakes:%d\nZeroRTT Eligible:%d\n\nCert Failures:%d\nTransport Failures:%d\n\n", r.FullHandshakes, r.ResumedHandshakes, r.ZeroRTTEligible, r.CertFailures, r.TransportFailures)
+		fmt.Println(tlsReport)
+		fmt.Println(formatLatencyReport("Handshake Latency", r.Handshake))
+	}
+	if *rate > 0 {
+		fmt.Println(fmt.Sprintf("Open-Model Dropped:%d\nOpen-Model Late:%d\n", r.Dropped, r.Late))
+	}
+	if *script != "" {
+		fmt.Println("Per-Endpoint Results:")
+		for path, stats := range r.Endpoints {
+			fmt.Println(fmt.Sprintf("  %s success:%d failure:%d", path, stats.Success, stats.Failure))
+		}
+		fmt.Println()
+	}
+	if *histOut != "" {
+		if err := r.writeHistograms(*histOut); err != nil {
+			fmt.Println(fmt.Sprintf("[ERROR] writing -hist-out:%s", err))
+		}
+	}
 	return nil
 }
 
+//formatLatencyReport renders the p50/p90/p99/p99.9/max summary of an HDR recorder,in microseconds
+func formatLatencyReport(label string, rec *latency.Recorder) string {
+	return fmt.Sprintf("%s (us):\np50:%d\np90:%d\np99:%d\np99.9:%d\nmax:%d\n",
+		label, rec.ValueAtPercentile(50), rec.ValueAtPercentile(90), rec.ValueAtPercentile(99), rec.ValueAtPercentile(99.9), rec.Max())
+}
+
+//writeHistograms dumps both the request-latency and handshake-latency histograms to path,as the
+//plain-text value,count lines of latency.Recorder.WriteLog (not byte-compatible with HdrHistogram logs),for offline analysis
+func (r *Reporter) writeHistograms(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintln(f, "# request latency")
+	if err := r.Latency.WriteLog(f); err != nil {
+		return err
+	}
+	fmt.Fprintln(f, "# handshake latency")
+	return r.Handshake.WriteLog(f)
+}
+
 func printHelp() {
 	fmt.Println("Usage: iBenchmark [options]")
 	flag.PrintDefaults()
@@ -156,18 +353,67 @@ func main() {
 	if host == "" || port == "" || path == "" || proto == "" {
 		printHelp()
 	}
+	if *script != "" {
+		plan, err := LoadScript(*script)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("[ERROR] loading -script:%s", err))
+			printHelp()
+		}
+		if *values != "" {
+			if err := plan.LoadValues(*values); err != nil {
+				fmt.Println(fmt.Sprintf("[ERROR] loading -values:%s", err))
+				printHelp()
+			}
+		}
+		scriptPlan = plan
+	}
+	if *protocol == "h2" {
+		if swithHttp {
+			fmt.Println("-p h2 requires an https:// url (HTTP/2 is negotiated over TLS via ALPN)")
+			printHelp()
+		}
+		if *rate > 0 {
+			fmt.Println("-p h2 is not supported with -rate (open-model load generation doesn't multiplex streams)")
+			printHelp()
+		}
+		swithH2 = true
+	}
 	ciphers := strings.Split(*cipherSuite, ",")
 	for _, c := range ciphers {
 		cipherSuites = append(cipherSuites, CipherSuites[c])
 	}
+	if *cert != "" {
+		certs, err := loadClientCerts(*cert, *key, *certRotate)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("[ERROR] loading -cert/-key:%s", err))
+			printHelp()
+		}
+		clientCerts = certs
+	}
+	if *ca != "" {
+		pool, err := loadRootCAs(*ca)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("[ERROR] loading -ca:%s", err))
+			printHelp()
+		}
+		rootCAs = pool
+	}
 
 	runtime.GOMAXPROCS(8)
 
+	if !swithHttp && *resume != "full" {
+		sessionCache = tls.NewLRUClientSessionCache(*concurrency)
+		if err := warmUpSession(); err != nil {
+			fmt.Println(fmt.Sprintf("[ERROR] session warm-up handshake failed:%s", err))
+			printHelp()
+		}
+	}
+
 	timeout := time.Duration(*dur) * time.Millisecond
 	finChan := make([]chan bool, *concurrency)
 
 	// number of connections to crypto server cluster
-	reporter := new(Reporter)
+	reporter := NewReporter()
 	reporter.Concurrency = *concurrency
 	reporter.Hostname = host
 	reporter.Port = port
@@ -176,21 +422,34 @@ func main() {
 	fmt.Println("benchmark start ")
 	// start workers
 	start := time.Now()
-	for i := 0; i < *concurrency; i = i + 1 {
-		finChan[i] = make(chan bool)
-		go worker(*reqNum, timeout, reporter, finChan[i])
-	}
+	if *rate > 0 {
+		openModelDriver(reporter, timeout)
+	} else {
+		for i := 0; i < *concurrency; i = i + 1 {
+			finChan[i] = make(chan bool)
+			if swithH2 {
+				go h2Worker(*reqNum, timeout, reporter, finChan[i], i)
+			} else {
+				go worker(*reqNum, timeout, reporter, finChan[i], i)
+			}
+		}
 
-	// wait for finish
-	for i := 0; i < *concurrency; i = i + 1 {
-		switch {
-		case <-(finChan[i]):
-			continue
+		// wait for finish
+		for i := 0; i < *concurrency; i = i + 1 {
+			switch {
+			case <-(finChan[i]):
+				continue
+			}
 		}
 	}
 	duration := time.Since(start).Nanoseconds() / (1000 * 1000)
 	reporter.TimeDur = duration
-	if *keepAlive {
+	if swithH2 {
+		reporter.RequestPerSecond = int(float64(reporter.TotalRequest) / (float64(reporter.TimeDur) / 1000))
+		reporter.ConnectionPerSecond = int(float64(*concurrency) / (float64(reporter.TimeDur) / 1000))
+		reporter.StreamsPerSecond = int(float64(reporter.StreamsPerSecond) / (float64(reporter.TimeDur) / 1000))
+		reporter.AvgStreamsPerConn = float64(reporter.TotalRequest) / float64(*concurrency)
+	} else if *rate > 0 || *keepAlive {
 		reporter.RequestPerSecond = int(float64(reporter.TotalRequest) / (float64(reporter.TimeDur) / 1000))
 		reporter.ConnectionPerSecond = 0
 	} else {
@@ -214,6 +473,152 @@ func main() {
 	reporter.Printer()
 }
 
+//build the tls.Config shared by every TLS dial,honoring -tls,-groups,-s,-resume and -cert/-key/-ca/
+//-servername/-insecure. workerID picks this worker's slot out of a -cert-rotate client cert pool.
+func buildTLSConfig(workerID int) *tls.Config {
+	config := &tls.Config{
+		InsecureSkipVerify: *insecure,
+		CipherSuites:       cipherSuites,
+		MinVersion:         TLSVersions[*tlsVersion],
+		MaxVersion:         TLSVersions[*tlsVersion],
+		ServerName:         *serverName,
+		RootCAs:            rootCAs,
+	}
+	if len(clientCerts) > 0 {
+		config.Certificates = []tls.Certificate{clientCerts[workerID%len(clientCerts)]}
+	}
+	if *groups != "" {
+		for _, name := range strings.Split(*groups, ",") {
+			if id, ok := CurveIDs[name]; ok {
+				config.CurvePreferences = append(config.CurvePreferences, id)
+			}
+		}
+	}
+	if *resume == "full" {
+		config.SessionTicketsDisabled = true
+	} else {
+		config.SessionTicketsDisabled = false
+		config.ClientSessionCache = sessionCache
+	}
+	return config
+}
+
+//loadClientCert loads a PEM client certificate/key pair for mTLS. certPath/keyPath may contain a
+//%d verb,filled in with idx,so -cert-rotate can build one pair per rotation slot from templates
+//like client-%d.pem. a package var so an HSM/PKCS#11-backed crypto.Signer key source can be
+//swapped in without touching loadClientCerts or its callers.
+var loadClientCert = func(certPath, keyPath string, idx int) (tls.Certificate, error) {
+	if strings.Contains(certPath, "%d") {
+		certPath = fmt.Sprintf(certPath, idx)
+	}
+	if strings.Contains(keyPath, "%d") {
+		keyPath = fmt.Sprintf(keyPath, idx)
+	}
+	return tls.LoadX509KeyPair(certPath, keyPath)
+}
+
+//loadClientCerts builds the -cert-rotate pool of client certificates,a single pair when rotate<=0.
+func loadClientCerts(certPath, keyPath string, rotate int) ([]tls.Certificate, error) {
+	n := rotate
+	if n <= 0 {
+		n = 1
+	}
+	certs := make([]tls.Certificate, n)
+	for i := 0; i < n; i++ {
+		cert, err := loadClientCert(certPath, keyPath, i)
+		if err != nil {
+			return nil, fmt.Errorf("rotation slot %d: %s", i, err)
+		}
+		certs[i] = cert
+	}
+	return certs, nil
+}
+
+//loadRootCAs reads a PEM bundle of root CAs to verify the server certificate against under -ca.
+func loadRootCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+//perform one handshake against the target and record it in the shared session cache,
+//so later workers' "ticket"/"psk"/"0rtt" handshakes have something to resume from.
+//TLS 1.3 delivers the session ticket as a post-handshake NewSessionTicket message,which
+//crypto/tls only parses and caches on a subsequent Read,so we drain one byte before closing.
+func warmUpSession() error {
+	conn, err := tls.Dial(network, address, buildTLSConfig(0))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(warmUpReadTimeout))
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err != nil && err != io.EOF && !os.IsTimeout(err) {
+		return err
+	}
+	return nil
+}
+
+//record a completed handshake's wall time into hsRec,and note whether it was resumed.
+//the counter updates go through countersMu since HTTPSGet/H2Dial run concurrently across workers.
+func recordHandshake(hsRec *latency.Recorder, r *Reporter, start time.Time, state tls.ConnectionState) {
+	hsRec.RecordValue(time.Since(start).Nanoseconds() / 1000)
+	r.countersMu.Lock()
+	if state.DidResume {
+		r.ResumedHandshakes += 1
+		if *resume == "0rtt" {
+			r.ZeroRTTEligible += 1
+		}
+	} else {
+		r.FullHandshakes += 1
+	}
+	r.countersMu.Unlock()
+}
+
+//classifyTLSError tells a certificate problem (bad/missing client cert,untrusted server cert,
+//hostname mismatch) apart from a transport-level dial failure (connection refused,timeout,etc.),
+//so handshake failures can be tallied separately in the Reporter.
+func classifyTLSError(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return true
+	}
+	var unknownAuthErr x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthErr) {
+		return true
+	}
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &hostnameErr) {
+		return true
+	}
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), "certificate") || strings.Contains(err.Error(), "tls:")
+}
+
+//recordHandshakeFailure tallies a failed TLS dial/handshake as either a certificate problem or a
+//transport problem,see classifyTLSError. goes through countersMu,same as recordHandshake.
+func recordHandshakeFailure(r *Reporter, err error) {
+	r.countersMu.Lock()
+	if classifyTLSError(err) {
+		r.CertFailures += 1
+	} else {
+		r.TransportFailures += 1
+	}
+	r.countersMu.Unlock()
+}
+
 //parse headers:'header1:v1;header2:v2'
 func parseHeader(in, reg string) (matches []string, err error) {
 	re := regexp.MustCompile(reg)
@@ -224,73 +629,120 @@ func parseHeader(in, reg string) (matches []string, err error) {
 	return
 }
 
+//staticIterator yields the same request forever,built from the -u/-m/-H/-B globals.
+//it's the request iterator used when -script is empty.
+func staticIterator() func() (*PlannedRequest, error) {
+	return func() (*PlannedRequest, error) {
+		return &PlannedRequest{Method: *method, Path: path, Headers: header, Body: *body}, nil
+	}
+}
+
+//newRequestIterator returns the request iterator a single worker should pull from:
+//the -script plan's own iterator when -script is set,otherwise staticIterator.
+func newRequestIterator(workerID int) func() (*PlannedRequest, error) {
+	if scriptPlan != nil {
+		return scriptPlan.NewIterator(workerID)
+	}
+	return staticIterator()
+}
+
 //establish a transport connection,and send queries if withReq on the connection
 //and the queries depend on the param dur or requests.if both were setted,depend on dur.See worker func.
 //otherwise close the connection immediately when established.
-func (r *Reporter) GetResponse(conn *net.Conn) error {
+//start is the instant latency is measured from: the dispatch time for closed-model
+//callers,or the scheduled arrival time for open-model callers (see openModelDriver),
+//so open-model latency reflects true response time rather than hiding queueing
+//delay behind the previous request (coordinated omission).
+//next supplies the request to send,either the static -u/-m/-H/-B request or the next
+//entry from a -script plan. workerID picks this worker's slot out of a -cert-rotate pool.
+func (r *Reporter) GetResponse(conn *net.Conn, latRec, hsRec *latency.Recorder, start time.Time, next func() (*PlannedRequest, error), workerID int) error {
 	var resp *http.Response
 	var err error
-	procStart := time.Now()
+	r.countersMu.Lock()
 	r.TotalRequest += 1
+	r.countersMu.Unlock()
+	pr, err := next()
+	if err != nil {
+		fmt.Println(fmt.Sprintf("[ERROR] rendering -script request:%s", err))
+		r.countersMu.Lock()
+		r.FailedRequest += 1
+		r.countersMu.Unlock()
+		return err
+	}
 	if !swithHttp {
 		if !*keepAlive {
-			resp, err = HTTPSGet()
+			resp, err = HTTPSGet(hsRec, r, pr, workerID)
 		} else {
-			resp, err = HTTPSGet_KeepAlive(conn)
+			resp, err = HTTPSGet_KeepAlive(hsRec, r, conn, pr, workerID)
 		}
 
 	} else {
 		if !*keepAlive {
-			resp, err = HTTPGet()
+			resp, err = HTTPGet(pr)
 		} else {
-			resp, err = HTTPGet_KeepAlive(conn)
+			resp, err = HTTPGet_KeepAlive(conn, pr)
 		}
 	}
 	if err != nil {
 		fmt.Println(fmt.Sprintf("HTTP(S) GET ERROR %v", err))
+		r.countersMu.Lock()
 		r.FailedRequest += 1
+		r.countersMu.Unlock()
 	}
 	if resp != nil {
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			r.Non2XXCode += 1
-		}
-		r.ContentLength = resp.ContentLength
-		for _, server := range resp.Header["Server"] {
-			if !servers[server] {
-				servers[server] = true
+		if *script != "" {
+			r.recordEndpoint(pr.Path, pr.Success(resp.StatusCode))
+		} else {
+			r.countersMu.Lock()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				r.Non2XXCode += 1
 			}
+			r.countersMu.Unlock()
 		}
+		r.countersMu.Lock()
+		r.ContentLength = resp.ContentLength
+		r.countersMu.Unlock()
+		recordServers(resp.Header["Server"])
 		if err := resp.Body.Close(); err != nil {
 			return err
 		}
 	}
-	end := time.Now()
-	elapse := end.Sub(procStart).Nanoseconds() / 1000
-	r.TimeTaken += elapse
+	latRec.RecordValue(time.Since(start).Nanoseconds() / 1000)
 	return err
 }
 
 //init a go routine,send queries on the transport layer ,the queries number depend on the reqNum or timeout.
 //And if both were setted,depends on timeout.
 //the finChan notify the main process wether this go routine has finished
-func worker(reqNum int, timeout time.Duration, reporter *Reporter, finChan chan bool) {
+//latency and handshake latency are recorded into this worker's own Recorders and merged
+//into the shared Reporter's histograms once the worker finishes.
+//workerID identifies this worker to a -script plan,so its templates can vary by {{.WorkerID}}.
+func worker(reqNum int, timeout time.Duration, reporter *Reporter, finChan chan bool, workerID int) {
 	end_time := time.After(timeout)
 	var conn net.Conn
+	latRec := latency.NewRecorder(latencyLowest, latencyHighest, latencySigDigs)
+	hsRec := latency.NewRecorder(latencyLowest, latencyHighest, latencySigDigs)
+	next := newRequestIterator(workerID)
 
-	defer func() {
+	// merge is called explicitly right before finChan is signalled,not left to a deferred
+	// func: the channel send is what tells main this worker's data is ready to read, so the
+	// merge must happen-before it, not merely before the surrounding return.
+	merge := func() {
 		if conn != nil {
 			conn.Close()
 		}
-
-	}()
+		reporter.Latency.Merge(latRec)
+		reporter.Handshake.Merge(hsRec)
+	}
 	if *dur != 0 {
 		for {
 			select {
 			case <-end_time:
+				merge()
 				finChan <- true
 				return
 			default:
-				err := reporter.GetResponse(&conn)
+				err := reporter.GetResponse(&conn, latRec, hsRec, time.Now(), next, workerID)
 				if err != nil {
 					fmt.Println(fmt.Sprintf("[ERROR]:%s", err))
 					if conn != nil {
@@ -304,7 +756,7 @@ func worker(reqNum int, timeout time.Duration, reporter *Reporter, finChan chan
 
 	} else {
 		for i := 0; i < reqNum; i++ {
-			err := reporter.GetResponse(&conn)
+			err := reporter.GetResponse(&conn, latRec, hsRec, time.Now(), next, workerID)
 			if err != nil {
 				fmt.Println(fmt.Sprintf("[ERROR]:%s", err))
 				if conn != nil {
@@ -313,6 +765,7 @@ func worker(reqNum int, timeout time.Duration, reporter *Reporter, finChan chan
 				}
 			}
 		}
+		merge()
 		finChan <- true
 		return
 	}
@@ -320,67 +773,60 @@ func worker(reqNum int, timeout time.Duration, reporter *Reporter, finChan chan
 }
 
 //establish a new tls connection and send send query if withReq
-func HTTPSGet() (*http.Response, error) {
-	// create tls config
-	config := tls.Config{
-		InsecureSkipVerify:     true,
-		SessionTicketsDisabled: true,
-		CipherSuites:           cipherSuites,
-	}
+func HTTPSGet(hsRec *latency.Recorder, r *Reporter, pr *PlannedRequest, workerID int) (*http.Response, error) {
+	config := buildTLSConfig(workerID)
 	// connect to tls server
-	conn, err := tls.Dial(network, address, &config)
+	hsStart := time.Now()
+	conn, err := tls.Dial(network, address, config)
 	if err != nil {
-		fmt.Errorf("client: dial: %s", err)
+		recordHandshakeFailure(r, err)
 		return nil, err
 	}
+	recordHandshake(hsRec, r, hsStart, conn.ConnectionState())
 	if *withReq {
-		return SendQuery(conn)
+		return SendQuery(conn, pr)
 	} else {
 		return nil, nil
 	}
 }
 
 //establish a new tls connection first time,and later reuse the connection,send query if withReq
-func HTTPSGet_KeepAlive(conn *net.Conn) (*http.Response, error) {
-	// create tls config
-	config := tls.Config{
-		InsecureSkipVerify:     true,
-		SessionTicketsDisabled: true,
-		CipherSuites:           cipherSuites,
-	}
-	var err error
+func HTTPSGet_KeepAlive(hsRec *latency.Recorder, r *Reporter, conn *net.Conn, pr *PlannedRequest, workerID int) (*http.Response, error) {
+	config := buildTLSConfig(workerID)
 	// connect to tls server
 	if *conn == nil {
-		*conn, err = tls.Dial(network, address, &config)
-		if err != nil {
-			fmt.Errorf("client: dial: %s", err)
-			return nil, err
+		hsStart := time.Now()
+		tlsConn, dialErr := tls.Dial(network, address, config)
+		if dialErr != nil {
+			recordHandshakeFailure(r, dialErr)
+			return nil, dialErr
 		}
-
+		recordHandshake(hsRec, r, hsStart, tlsConn.ConnectionState())
+		*conn = tlsConn
 	}
 	if *withReq {
-		return SendQuery(*conn)
+		return SendQuery(*conn, pr)
 	} else {
 		return nil, nil
 	}
 }
 
 //establish a new tcp connection and send query if withReq
-func HTTPGet() (*http.Response, error) {
+func HTTPGet(pr *PlannedRequest) (*http.Response, error) {
 	conn, err := net.Dial(network, address)
 	if err != nil {
 		return nil, err
 	}
 
 	if *withReq {
-		return SendQuery(conn)
+		return SendQuery(conn, pr)
 	} else {
 		return nil, nil
 	}
 }
 
 //establish a new tcp connection first time,and later reuse the connection,send query if withReq
-func HTTPGet_KeepAlive(conn *net.Conn) (*http.Response, error) {
+func HTTPGet_KeepAlive(conn *net.Conn, pr *PlannedRequest) (*http.Response, error) {
 	var err error
 	if *conn == nil {
 		*conn, err = net.Dial(network, address)
@@ -390,27 +836,29 @@ func HTTPGet_KeepAlive(conn *net.Conn) (*http.Response, error) {
 
 	}
 	if *withReq {
-		return SendQuery(*conn)
+		return SendQuery(*conn, pr)
 	} else {
 		return nil, nil
 	}
 }
 
-//send query on the established connection,and get the response
-func SendQuery(conn net.Conn) (*http.Response, error) {
+//send query on the established connection,and get the response. pr carries the rendered
+//method/path/headers/body to send,either the static -u/-m/-H/-B request or the next
+//entry from a -script plan.
+func SendQuery(conn net.Conn, pr *PlannedRequest) (*http.Response, error) {
 	if conn == nil {
 		return nil, errors.New("send queries on the nil or closed connection")
 	}
-	req, err := http.NewRequest(*method, *url, strings.NewReader(*body))
+	req, err := http.NewRequest(pr.Method, fmt.Sprintf("%s://%s%s", proto, address, pr.Path), strings.NewReader(pr.Body))
 	if err != nil {
 		return nil, err
 	}
-	req.Header = header
-	if header.Get("Host") != "" {
+	req.Header = pr.Headers
+	if pr.Headers.Get("Host") != "" {
 		//I think this should be a golang http pkg's bug.
 		//if I put Host Header in the req.Header,golang pkg can't handle it.
 		//So I have to hanlde the Host header in my code.
-		req.Host = header.Get("Host")
+		req.Host = pr.Headers.Get("Host")
 	}
 	if err := req.Write(conn); err != nil {
 		return nil, err
@@ -428,3 +876,251 @@ func SendQuery(conn net.Conn) (*http.Response, error) {
 	}
 	return resp, nil
 }
+
+//dial a single TLS connection negotiated via ALPN "h2" and wrap it as an http2.ClientConn.
+//one connection is established per worker; requests are issued as concurrent streams on top of it.
+func H2Dial(hsRec *latency.Recorder, r *Reporter, workerID int) (*http2.ClientConn, error) {
+	config := buildTLSConfig(workerID)
+	config.NextProtos = []string{"h2"}
+	hsStart := time.Now()
+	conn, err := tls.Dial(network, address, config)
+	if err != nil {
+		recordHandshakeFailure(r, err)
+		return nil, err
+	}
+	if err := conn.Handshake(); err != nil {
+		recordHandshakeFailure(r, err)
+		conn.Close()
+		return nil, err
+	}
+	recordHandshake(hsRec, r, hsStart, conn.ConnectionState())
+	if p := conn.ConnectionState().NegotiatedProtocol; p != "h2" {
+		conn.Close()
+		return nil, errors.New(fmt.Sprintf("server did not negotiate h2 via ALPN,got %q", p))
+	}
+	transport := &http2.Transport{
+		MaxReadFrameSize: uint32(*maxFrameSize),
+	}
+	return transport.NewClientConn(conn)
+}
+
+//issue a single stream on an already-established http2.ClientConn
+func SendQueryH2(cc *http2.ClientConn, pr *PlannedRequest) (*http.Response, error) {
+	req, err := http.NewRequest(pr.Method, fmt.Sprintf("%s://%s%s", proto, address, pr.Path), strings.NewReader(pr.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = pr.Headers
+	if pr.Headers.Get("Host") != "" {
+		req.Host = pr.Headers.Get("Host")
+	}
+	resp, err := cc.RoundTrip(req)
+	if err != nil {
+		if _, ok := err.(http2.StreamError); ok {
+			return nil, err
+		}
+		if err == http2.ErrNoCachedConn {
+			return nil, err
+		}
+		return nil, err
+	}
+	if *out {
+		var bout bytes.Buffer
+		io.Copy(&bout, resp.Body)
+		if bout.String() != "" {
+			fmt.Println(bout.String())
+		}
+	}
+	return resp, nil
+}
+
+//dial one h2 connection and fire *streams concurrent requests per iteration,recording
+//per-stream results on the shared Reporter. mirrors worker()'s reqNum/timeout semantics
+//but counts whole connections instead of per-request dial/reuse.
+//workerID identifies this worker to a -script plan,so its templates can vary by {{.WorkerID}}.
+func h2Worker(reqNum int, timeout time.Duration, reporter *Reporter, finChan chan bool, workerID int) {
+	latRec := latency.NewRecorder(latencyLowest, latencyHighest, latencySigDigs)
+	hsRec := latency.NewRecorder(latencyLowest, latencyHighest, latencySigDigs)
+	next := newRequestIterator(workerID)
+	// merge is called explicitly right before finChan is signalled,not left to a deferred
+	// func: the channel send is what tells main this worker's data is ready to read, so the
+	// merge must happen-before it, not merely before the surrounding return.
+	merge := func() {
+		reporter.Latency.Merge(latRec)
+		reporter.Handshake.Merge(hsRec)
+	}
+
+	cc, err := H2Dial(hsRec, reporter, workerID)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("[ERROR] h2 dial:%s", err))
+		merge()
+		finChan <- true
+		return
+	}
+	defer cc.Close()
+
+	fire := func() {
+		// fetched up front,sequentially,since next isn't safe for concurrent callers
+		prs := make([]*PlannedRequest, *streams)
+		for s := 0; s < *streams; s++ {
+			pr, err := next()
+			if err != nil {
+				fmt.Println(fmt.Sprintf("[ERROR] rendering -script request:%s", err))
+				reporter.countersMu.Lock()
+				reporter.FailedRequest += 1
+				reporter.countersMu.Unlock()
+				continue
+			}
+			prs[s] = pr
+		}
+		done := make(chan bool, *streams)
+		for s := 0; s < *streams; s++ {
+			pr := prs[s]
+			if pr == nil {
+				done <- true
+				continue
+			}
+			go func() {
+				procStart := time.Now()
+				resp, err := SendQueryH2(cc, pr)
+				reporter.recordStreamResult(pr, resp, err)
+				if resp != nil {
+					resp.Body.Close()
+				}
+				latRec.RecordValue(time.Since(procStart).Nanoseconds() / 1000)
+				done <- true
+			}()
+		}
+		for s := 0; s < *streams; s++ {
+			<-done
+		}
+	}
+
+	if *dur != 0 {
+		end_time := time.After(timeout)
+		for {
+			select {
+			case <-end_time:
+				merge()
+				finChan <- true
+				return
+			default:
+				fire()
+			}
+		}
+	} else {
+		for i := 0; i < reqNum; i++ {
+			fire()
+		}
+		merge()
+		finChan <- true
+		return
+	}
+}
+
+// lateFactor is how many scheduled intervals a request can be dispatched behind its
+// scheduled start before it's counted as "late" in the Reporter.
+const lateFactor = 2
+
+//openModelDriver runs an open-model load generator: request start times are scheduled
+//ahead of time from a fixed-rate ticker or a Poisson process,and dispatched onto a
+//bounded pool of goroutines through the schedule channel. Unlike worker(),a goroutine
+//here never waits for the previous response before the next request is due,so queueing
+//caused by an overloaded server shows up as latency instead of being hidden behind the
+//closed-model request/response lockstep (coordinated omission). If the pool falls behind
+//and the channel is full,the request is dropped and counted rather than blocking the
+//scheduler; requests that do get dispatched well behind schedule are counted as late.
+//HTTP/2 multiplexing (-p h2) is not supported in open-model mode; main rejects that combination at startup.
+func openModelDriver(reporter *Reporter, timeout time.Duration) {
+	pool := *openWorkers
+	if pool <= 0 {
+		pool = *concurrency
+	}
+	if pool < 1 {
+		pool = 1
+	}
+	interval := time.Duration(float64(time.Second) / *rate)
+
+	schedule := make(chan time.Time, pool*4)
+	var wg sync.WaitGroup
+	wg.Add(pool)
+	for i := 0; i < pool; i++ {
+		go func(workerID int) {
+			defer wg.Done()
+			var conn net.Conn
+			latRec := latency.NewRecorder(latencyLowest, latencyHighest, latencySigDigs)
+			hsRec := latency.NewRecorder(latencyLowest, latencyHighest, latencySigDigs)
+			next := newRequestIterator(workerID)
+			defer func() {
+				if conn != nil {
+					conn.Close()
+				}
+				reporter.Latency.Merge(latRec)
+				reporter.Handshake.Merge(hsRec)
+			}()
+			for scheduled := range schedule {
+				if time.Since(scheduled) > lateFactor*interval {
+					reporter.countersMu.Lock()
+					reporter.Late += 1
+					reporter.countersMu.Unlock()
+				}
+				if err := reporter.GetResponse(&conn, latRec, hsRec, scheduled, next, workerID); err != nil {
+					fmt.Println(fmt.Sprintf("[ERROR]:%s", err))
+					if conn != nil {
+						conn.Close()
+						conn = nil
+					}
+				}
+			}
+		}(i)
+	}
+
+	var end_time <-chan time.Time
+	if *dur != 0 {
+		end_time = time.After(timeout)
+	}
+	next := time.Now()
+	count := 0
+scheduler:
+	for {
+		if *dur == 0 && count >= reqNumOrDefault() {
+			break
+		}
+		if *dur != 0 {
+			select {
+			case <-end_time:
+				break scheduler
+			default:
+			}
+		}
+		if wait := time.Until(next); wait > 0 {
+			time.Sleep(wait)
+		}
+		scheduled := next
+		select {
+		case schedule <- scheduled:
+		default:
+			fmt.Println(fmt.Sprintf("[WARN] backlog: dispatch pool saturated,dropping request scheduled for %s", scheduled.Format(time.RFC3339Nano)))
+			reporter.Dropped += 1
+		}
+		count++
+		if *arrival == "poisson" {
+			next = next.Add(time.Duration(rand.ExpFloat64() * float64(interval)))
+		} else {
+			next = next.Add(interval)
+		}
+	}
+	close(schedule)
+	wg.Wait()
+}
+
+//reqNumOrDefault is how many requests openModelDriver schedules when -t is not set. In
+//open-model mode -r counts the total scheduled requests across the whole dispatch pool,not
+//requests per connection. When -r is not set it schedules a single request,whereas worker()
+//sends none in that case.
+func reqNumOrDefault() int {
+	if *reqNum > 0 {
+		return *reqNum
+	}
+	return 1
+}
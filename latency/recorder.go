@@ -0,0 +1,240 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package latency implements a small HDR-style (High Dynamic Range)
+// histogram used to record request/handshake latencies without losing
+// tail resolution the way a running sum-and-average does. Values are
+// bucketed log-linearly: within each power-of-two range, the range is
+// split into a fixed number of equal-width sub-buckets, so relative
+// precision (number of significant digits) stays constant from the
+// lowest to the highest trackable value.
+package latency
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+)
+
+// Recorder is a log-linear latency histogram. All methods are safe for
+// concurrent use; a single Recorder can be shared by several workers, or
+// each worker can keep its own and Merge it into a final Recorder once done.
+type Recorder struct {
+	mu sync.Mutex
+
+	lowestTrackableValue  int64
+	highestTrackableValue int64
+	significantDigits     int
+
+	unitMagnitude               uint
+	subBucketHalfCountMagnitude uint
+	subBucketCount              int64
+	subBucketHalfCount          int64
+	subBucketMask               int64
+	bucketCount                 int
+
+	counts     []int64
+	totalCount int64
+	maxValue   int64
+}
+
+// NewRecorder creates a Recorder tracking values in
+// [lowestTrackableValue, highestTrackableValue] with significantDigits
+// decimal digits of resolution (HdrHistogram callers typically use 3).
+func NewRecorder(lowestTrackableValue, highestTrackableValue int64, significantDigits int) *Recorder {
+	if lowestTrackableValue < 1 {
+		lowestTrackableValue = 1
+	}
+	largestValueWithSingleUnitResolution := int64(2 * pow10(significantDigits))
+	subBucketCountMagnitude := uint(ceilLog2(largestValueWithSingleUnitResolution))
+	subBucketHalfCountMagnitude := uint(0)
+	if subBucketCountMagnitude > 1 {
+		subBucketHalfCountMagnitude = subBucketCountMagnitude - 1
+	}
+	unitMagnitude := uint(floorLog2(lowestTrackableValue))
+	subBucketCount := int64(1) << (subBucketHalfCountMagnitude + 1)
+	subBucketHalfCount := subBucketCount / 2
+	subBucketMask := (subBucketCount - 1) << unitMagnitude
+
+	bucketCount := 1
+	smallestUntrackableValue := subBucketCount << unitMagnitude
+	for smallestUntrackableValue < highestTrackableValue {
+		smallestUntrackableValue <<= 1
+		bucketCount++
+	}
+	countsArrayLength := int((bucketCount + 1)) * int(subBucketHalfCount)
+
+	return &Recorder{
+		lowestTrackableValue:        lowestTrackableValue,
+		highestTrackableValue:       highestTrackableValue,
+		significantDigits:           significantDigits,
+		unitMagnitude:               unitMagnitude,
+		subBucketHalfCountMagnitude: subBucketHalfCountMagnitude,
+		subBucketCount:              subBucketCount,
+		subBucketHalfCount:          subBucketHalfCount,
+		subBucketMask:               subBucketMask,
+		bucketCount:                 bucketCount,
+		counts:                      make([]int64, countsArrayLength),
+	}
+}
+
+// RecordValue records a single observation, clamped to the histogram's
+// configured range.
+func (r *Recorder) RecordValue(value int64) {
+	if value < r.lowestTrackableValue {
+		value = r.lowestTrackableValue
+	}
+	if value > r.highestTrackableValue {
+		value = r.highestTrackableValue
+	}
+	idx := r.countsIndexFor(value)
+
+	r.mu.Lock()
+	r.counts[idx]++
+	r.totalCount++
+	if value > r.maxValue {
+		r.maxValue = value
+	}
+	r.mu.Unlock()
+}
+
+// Merge folds other's counts into r. Both Recorders must have been created
+// with the same range/significantDigits.
+func (r *Recorder) Merge(other *Recorder) {
+	other.mu.Lock()
+	counts := make([]int64, len(other.counts))
+	copy(counts, other.counts)
+	total := other.totalCount
+	max := other.maxValue
+	other.mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range counts {
+		r.counts[i] += c
+	}
+	r.totalCount += total
+	if max > r.maxValue {
+		r.maxValue = max
+	}
+}
+
+// TotalCount returns the number of values recorded so far.
+func (r *Recorder) TotalCount() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.totalCount
+}
+
+// Max returns the largest value recorded so far.
+func (r *Recorder) Max() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.maxValue
+}
+
+// ValueAtPercentile returns the value at or below which percentile% of recorded
+// observations fall (e.g. 50, 90, 99, 99.9), rounded down to the lowest value of
+// the bucket holding the observation at that rank.
+func (r *Recorder) ValueAtPercentile(percentile float64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.totalCount == 0 {
+		return 0
+	}
+	if percentile > 100 {
+		percentile = 100
+	}
+	target := int64((percentile / 100) * float64(r.totalCount))
+	if target < 1 {
+		target = 1
+	}
+	var cumulative int64
+	for i, c := range r.counts {
+		if c == 0 {
+			continue
+		}
+		cumulative += c
+		if cumulative >= target {
+			return r.valueFromIndex(i)
+		}
+	}
+	return r.maxValue
+}
+
+// WriteLog writes a simple, human-readable histogram log: one
+// "value,count" line per populated bucket, ordered from lowest to
+// highest. It is a readable stand-in for the binary HdrHistogram
+// interval-log format, suitable for plotting or diffing offline, but it
+// is not byte-compatible with the reference HdrHistogram log encoder.
+func (r *Recorder) WriteLog(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# HDR histogram log (lowest=%d highest=%d sigdigits=%d totalCount=%d)\n",
+		r.lowestTrackableValue, r.highestTrackableValue, r.significantDigits, r.totalCount)
+	for i, c := range r.counts {
+		if c == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "%d,%d\n", r.valueFromIndex(i), c)
+	}
+	return bw.Flush()
+}
+
+func (r *Recorder) countsIndexFor(value int64) int {
+	bucketIdx := r.bucketIndexFor(value)
+	subBucketIdx := r.subBucketIndexFor(value, bucketIdx)
+	bucketBaseIdx := (bucketIdx + 1) << r.subBucketHalfCountMagnitude
+	offsetInBucket := subBucketIdx - r.subBucketHalfCount
+	return int(bucketBaseIdx + offsetInBucket)
+}
+
+func (r *Recorder) bucketIndexFor(value int64) int64 {
+	pow2Ceiling := int64(64 - bits.LeadingZeros64(uint64(value)|uint64(r.subBucketMask)))
+	return pow2Ceiling - int64(r.unitMagnitude) - int64(r.subBucketHalfCountMagnitude+1)
+}
+
+func (r *Recorder) subBucketIndexFor(value int64, bucketIdx int64) int64 {
+	return value >> (uint(bucketIdx) + r.unitMagnitude)
+}
+
+func (r *Recorder) valueFromIndex(index int) int64 {
+	bucketIdx := int64(index)>>r.subBucketHalfCountMagnitude - 1
+	subBucketIdx := int64(index)&(r.subBucketHalfCount-1) + r.subBucketHalfCount
+	if bucketIdx < 0 {
+		subBucketIdx -= r.subBucketHalfCount
+		bucketIdx = 0
+	}
+	return subBucketIdx << (uint(bucketIdx) + r.unitMagnitude)
+}
+
+func floorLog2(value int64) int {
+	return 63 - bits.LeadingZeros64(uint64(value))
+}
+
+func ceilLog2(value int64) int {
+	return 64 - bits.LeadingZeros64(uint64(value-1))
+}
+
+func pow10(n int) int64 {
+	result := int64(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+	return result
+}
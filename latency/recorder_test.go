@@ -0,0 +1,97 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package latency
+
+import "testing"
+
+func newTestRecorder() *Recorder {
+	return NewRecorder(1, 60*1000*1000, 3)
+}
+
+func TestRecordValueAndPercentiles(t *testing.T) {
+	r := newTestRecorder()
+	for v := int64(1); v <= 100; v++ {
+		r.RecordValue(v)
+	}
+	if got := r.TotalCount(); got != 100 {
+		t.Fatalf("TotalCount() = %d, want 100", got)
+	}
+	if got := r.Max(); got != 100 {
+		t.Fatalf("Max() = %d, want 100", got)
+	}
+	if got := r.ValueAtPercentile(50); got < 48 || got > 52 {
+		t.Fatalf("ValueAtPercentile(50) = %d, want close to 50", got)
+	}
+	if got := r.ValueAtPercentile(100); got != 100 {
+		t.Fatalf("ValueAtPercentile(100) = %d, want 100", got)
+	}
+}
+
+func TestRecordValueClampsToRange(t *testing.T) {
+	r := newTestRecorder()
+	r.RecordValue(0)
+	r.RecordValue(1000 * 1000 * 1000)
+	if got := r.TotalCount(); got != 2 {
+		t.Fatalf("TotalCount() = %d, want 2", got)
+	}
+	if got := r.Max(); got != 60*1000*1000 {
+		t.Fatalf("Max() = %d, want clamped to highestTrackableValue", got)
+	}
+}
+
+func TestValueAtPercentileEmpty(t *testing.T) {
+	r := newTestRecorder()
+	if got := r.ValueAtPercentile(99); got != 0 {
+		t.Fatalf("ValueAtPercentile on empty recorder = %d, want 0", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := newTestRecorder()
+	b := newTestRecorder()
+	for v := int64(1); v <= 50; v++ {
+		a.RecordValue(v)
+	}
+	for v := int64(51); v <= 100; v++ {
+		b.RecordValue(v)
+	}
+	a.Merge(b)
+	if got := a.TotalCount(); got != 100 {
+		t.Fatalf("TotalCount() after Merge = %d, want 100", got)
+	}
+	if got := a.Max(); got != 100 {
+		t.Fatalf("Max() after Merge = %d, want 100", got)
+	}
+	// b must be unaffected by merging into a
+	if got := b.TotalCount(); got != 50 {
+		t.Fatalf("other.TotalCount() after Merge = %d, want unchanged 50", got)
+	}
+}
+
+func TestValueAtPercentileMonotonic(t *testing.T) {
+	r := newTestRecorder()
+	for v := int64(1); v <= 1000; v++ {
+		r.RecordValue(v)
+	}
+	prev := int64(0)
+	for _, p := range []float64{1, 10, 50, 90, 99, 99.9, 100} {
+		got := r.ValueAtPercentile(p)
+		if got < prev {
+			t.Fatalf("ValueAtPercentile(%v) = %d, not monotonic after previous %d", p, got, prev)
+		}
+		prev = got
+	}
+}
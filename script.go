@@ -0,0 +1,215 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// requestSpec is one templated request description as it appears in a -script file.
+// Path/Body and each header value are parsed as Go templates and re-executed
+// for every request, so {{.WorkerID}}, {{.Seq}}, {{.RandInt}} and {{.Values.col}}
+// can vary per call. Method is used as written (GET when empty); it is not a template.
+// Expect,if non-empty,lists the HTTP status codes this endpoint
+// considers successful; an empty Expect falls back to "2xx is success".
+type requestSpec struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Expect  []int             `json:"expect"`
+}
+
+type compiledRequest struct {
+	method  string
+	path    *template.Template
+	headers map[string]*template.Template
+	body    *template.Template
+	expect  []int
+}
+
+// templateData is the value each template in a ScriptPlan is executed with.
+type templateData struct {
+	WorkerID int
+	Seq      int
+	RandInt  int
+	Values   map[string]string
+}
+
+// ScriptPlan is a parsed -script file plus an optional -values pool. Each worker
+// should call NewIterator to get its own independent, stateful request iterator.
+type ScriptPlan struct {
+	requests []*compiledRequest
+	values   []map[string]string
+}
+
+// PlannedRequest is what an iterator yields: a fully rendered request,ready for SendQuery.
+type PlannedRequest struct {
+	Method  string
+	Path    string
+	Headers http.Header
+	Body    string
+	Expect  []int
+}
+
+// LoadScript parses a -script file: a JSON array of requestSpec objects.
+func LoadScript(path string) (*ScriptPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []requestSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("parsing -script %s: %s", path, err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("-script %s defines no requests", path)
+	}
+	plan := &ScriptPlan{}
+	for i, spec := range specs {
+		cr, err := compileRequestSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("-script %s, request %d: %s", path, i, err)
+		}
+		plan.requests = append(plan.requests, cr)
+	}
+	return plan, nil
+}
+
+// LoadValues reads a -values CSV file (header row + data rows) into row-keyed maps.
+func (p *ScriptPlan) LoadValues(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(rows) < 2 {
+		return fmt.Errorf("-values %s needs a header row plus at least one data row", path)
+	}
+	header := rows[0]
+	for _, row := range rows[1:] {
+		record := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(row) {
+				record[col] = row[i]
+			}
+		}
+		p.values = append(p.values, record)
+	}
+	return nil
+}
+
+func compileRequestSpec(spec requestSpec) (*compiledRequest, error) {
+	method := spec.Method
+	if method == "" {
+		method = "GET"
+	}
+	pathTmpl, err := template.New("path").Parse(spec.Path)
+	if err != nil {
+		return nil, fmt.Errorf("path template: %s", err)
+	}
+	bodyTmpl, err := template.New("body").Parse(spec.Body)
+	if err != nil {
+		return nil, fmt.Errorf("body template: %s", err)
+	}
+	headers := make(map[string]*template.Template, len(spec.Headers))
+	for name, value := range spec.Headers {
+		t, err := template.New(name).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s template: %s", name, err)
+		}
+		headers[name] = t
+	}
+	return &compiledRequest{
+		method:  method,
+		path:    pathTmpl,
+		headers: headers,
+		body:    bodyTmpl,
+		expect:  spec.Expect,
+	}, nil
+}
+
+// NewIterator returns a stateful closure that yields one PlannedRequest per call,
+// cycling through the plan's requests in order and advancing Seq every call. Each
+// worker should use its own iterator, so WorkerID and Seq are meaningful per-worker.
+func (p *ScriptPlan) NewIterator(workerID int) func() (*PlannedRequest, error) {
+	seq := 0
+	return func() (*PlannedRequest, error) {
+		spec := p.requests[seq%len(p.requests)]
+		data := templateData{WorkerID: workerID, Seq: seq, RandInt: rand.Int()}
+		if len(p.values) > 0 {
+			data.Values = p.values[seq%len(p.values)]
+		}
+		seq++
+
+		path, err := renderTemplate(spec.path, data)
+		if err != nil {
+			return nil, err
+		}
+		body, err := renderTemplate(spec.body, data)
+		if err != nil {
+			return nil, err
+		}
+		hdr := make(http.Header, len(spec.headers))
+		for name, tmpl := range spec.headers {
+			value, err := renderTemplate(tmpl, data)
+			if err != nil {
+				return nil, err
+			}
+			hdr.Set(name, value)
+		}
+		return &PlannedRequest{
+			Method:  spec.method,
+			Path:    path,
+			Headers: hdr,
+			Body:    body,
+			Expect:  spec.expect,
+		}, nil
+	}
+}
+
+func renderTemplate(t *template.Template, data templateData) (string, error) {
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Success reports whether statusCode counts as a success for this planned request:
+// an explicit Expect list must contain it,otherwise any 2xx status counts.
+func (pr *PlannedRequest) Success(statusCode int) bool {
+	if len(pr.Expect) > 0 {
+		for _, code := range pr.Expect {
+			if code == statusCode {
+				return true
+			}
+		}
+		return false
+	}
+	return statusCode >= 200 && statusCode < 300
+}
@@ -0,0 +1,124 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("writing %s: %s", path, err)
+	}
+	return path
+}
+
+func TestScriptPlanRendersTemplates(t *testing.T) {
+	path := writeTempFile(t, "script.json", `[
+		{"method":"GET","path":"/w{{.WorkerID}}/s{{.Seq}}","headers":{"X-Seq":"{{.Seq}}"},"body":""}
+	]`)
+	plan, err := LoadScript(path)
+	if err != nil {
+		t.Fatalf("LoadScript: %s", err)
+	}
+	next := plan.NewIterator(7)
+	pr, err := next()
+	if err != nil {
+		t.Fatalf("iterator: %s", err)
+	}
+	if pr.Path != "/w7/s0" {
+		t.Fatalf("Path = %q, want /w7/s0", pr.Path)
+	}
+	if got := pr.Headers.Get("X-Seq"); got != "0" {
+		t.Fatalf("X-Seq header = %q, want 0", got)
+	}
+	pr, err = next()
+	if err != nil {
+		t.Fatalf("iterator: %s", err)
+	}
+	if pr.Path != "/w7/s1" {
+		t.Fatalf("Path = %q, want /w7/s1 (Seq should advance per call)", pr.Path)
+	}
+}
+
+func TestScriptPlanCyclesRequests(t *testing.T) {
+	path := writeTempFile(t, "script.json", `[
+		{"method":"GET","path":"/a"},
+		{"method":"GET","path":"/b"}
+	]`)
+	plan, err := LoadScript(path)
+	if err != nil {
+		t.Fatalf("LoadScript: %s", err)
+	}
+	next := plan.NewIterator(0)
+	wantPaths := []string{"/a", "/b", "/a", "/b"}
+	for i, want := range wantPaths {
+		pr, err := next()
+		if err != nil {
+			t.Fatalf("iterator call %d: %s", i, err)
+		}
+		if pr.Path != want {
+			t.Fatalf("call %d: Path = %q, want %q", i, pr.Path, want)
+		}
+	}
+}
+
+func TestScriptPlanValuesWraparound(t *testing.T) {
+	scriptPath := writeTempFile(t, "script.json", `[
+		{"method":"GET","path":"/{{.Values.id}}"}
+	]`)
+	plan, err := LoadScript(scriptPath)
+	if err != nil {
+		t.Fatalf("LoadScript: %s", err)
+	}
+	valuesPath := writeTempFile(t, "values.csv", "id\n1\n2\n3\n")
+	if err := plan.LoadValues(valuesPath); err != nil {
+		t.Fatalf("LoadValues: %s", err)
+	}
+	next := plan.NewIterator(0)
+	want := []string{"/1", "/2", "/3", "/1", "/2"}
+	for i, w := range want {
+		pr, err := next()
+		if err != nil {
+			t.Fatalf("iterator call %d: %s", i, err)
+		}
+		if pr.Path != w {
+			t.Fatalf("call %d: Path = %q, want %q (values rows should wrap around)", i, pr.Path, w)
+		}
+	}
+}
+
+func TestPlannedRequestSuccess(t *testing.T) {
+	pr := &PlannedRequest{Expect: nil}
+	if !pr.Success(204) {
+		t.Fatalf("Success(204) with no Expect = false, want true (any 2xx)")
+	}
+	if pr.Success(404) {
+		t.Fatalf("Success(404) with no Expect = true, want false")
+	}
+
+	pr = &PlannedRequest{Expect: []int{404, 409}}
+	if !pr.Success(404) {
+		t.Fatalf("Success(404) with Expect=[404,409] = false, want true")
+	}
+	if pr.Success(200) {
+		t.Fatalf("Success(200) with Expect=[404,409] = true, want false")
+	}
+}